@@ -12,24 +12,24 @@ var runs = 0
 func BenchmarkCacheWithMisses(b *testing.B) {
 	runs++
 	c := New("bench" + strconv.Itoa(runs))
-	c.RegisterFetcher(func(key string) ([]byte, error) {
+	c.RegisterFetcher(func(key StrKey) ([]byte, error) {
 		return []byte(key), nil
 	})
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		c.Get(strconv.Itoa(i))
+		c.Get(StrKey(strconv.Itoa(i)))
 	}
 }
 
 func BenchmarkCacheWithHits(b *testing.B) {
 	runs++
 	c := New("bench" + strconv.Itoa(runs))
-	c.RegisterFetcher(func(key string) ([]byte, error) {
+	c.RegisterFetcher(func(key StrKey) ([]byte, error) {
 		return []byte(key), nil
 	})
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		c.Get("1")
+		c.Get(StrKey("1"))
 	}
 }
 
@@ -40,3 +40,33 @@ func BenchmarkNormalMapWithMisses(b *testing.B) {
 		m[name] = []byte(name)
 	}
 }
+
+func BenchmarkCacheParallelHits(b *testing.B) {
+	runs++
+	c := New("benchpar" + strconv.Itoa(runs))
+	c.RegisterFetcher(func(key StrKey) ([]byte, error) {
+		return []byte(key), nil
+	})
+	c.Get(StrKey("1"))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.Get(StrKey("1"))
+		}
+	})
+}
+
+func BenchmarkShardedCacheParallelHits(b *testing.B) {
+	runs++
+	c := NewSharded("benchpar"+strconv.Itoa(runs), DefaultShards)
+	c.RegisterFetcher(func(key StrKey) ([]byte, error) {
+		return []byte(key), nil
+	})
+	c.Get(StrKey("1"))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.Get(StrKey("1"))
+		}
+	})
+}
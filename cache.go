@@ -3,10 +3,12 @@
 package rcache
 
 import (
+	"errors"
 	"expvar"
 	"fmt"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,23 +19,37 @@ var (
 
 type cache struct {
 	fetchers        map[reflect.Type]reflect.Value
+	fetcherOpts     map[reflect.Type]FetcherOptions
 	cache           map[CacheKey]*CacheEntry
 	cacheLock       sync.Mutex
 	cacheSize       int64
 	cacheSizeExpVar *expvar.Int
+
+	// dependents is a reverse index: dependents[k] holds the set of cached
+	// keys whose Dependencies() include k. It lets Invalidate(key, true) walk
+	// straight to a key's dependents instead of scanning the whole cache.
+	dependents map[CacheKey]map[CacheKey]struct{}
 }
 
 // New returns a new cache with no built-in eviction strategy. The cache's name
 // is exposed with stats in expvar.
-func New(name string) Cache {
-	return &cache{
+func New(name string, opts ...Option) Cache {
+	co := newCacheOptions(opts)
+	c := &cache{
 		fetchers:        make(map[reflect.Type]reflect.Value),
+		fetcherOpts:     make(map[reflect.Type]FetcherOptions),
 		cache:           make(map[CacheKey]*CacheEntry),
 		cacheSizeExpVar: expvar.NewInt(fmt.Sprintf("cacheSize (%s)", name)),
+		dependents:      make(map[CacheKey]map[CacheKey]struct{}),
 	}
+	return wrapMiddleware(c, co.middleware)
 }
 
 func (c *cache) RegisterFetcher(fn interface{}) {
+	c.RegisterFetcherWithOptions(fn, FetcherOptions{})
+}
+
+func (c *cache) RegisterFetcherWithOptions(fn interface{}, opts FetcherOptions) {
 	v := reflect.ValueOf(fn)
 	t := v.Type()
 	assertValidFetcher(t)
@@ -44,6 +60,7 @@ func (c *cache) RegisterFetcher(fn interface{}) {
 	// Map the argument type to the fetcher.
 	arg := t.In(0)
 	c.fetchers[arg] = v
+	c.fetcherOpts[arg] = opts
 }
 
 func (c *cache) Get(key CacheKey) ([]byte, error) {
@@ -56,7 +73,22 @@ func (c *cache) GetCacheEntry(key CacheKey) *CacheEntry {
 	if entry, ok := c.cache[key]; ok {
 		c.cacheLock.Unlock()
 		entry.wg.Wait()
+
+		if !entry.Expiry.IsZero() && time.Now().After(entry.Expiry) {
+			// Past TTL, including any stale-while-revalidate window: treat
+			// this as a miss rather than serving stale data forever.
+			c.cacheLock.Lock()
+			if c.cache[key] == entry {
+				c.invalidate(key, false)
+			}
+			c.cacheLock.Unlock()
+			return c.GetCacheEntry(key)
+		}
+
 		entry.Accessed = time.Now()
+		if !entry.staleAt.IsZero() && time.Now().After(entry.staleAt) {
+			c.refreshInBackground(key, entry)
+		}
 		return entry
 	}
 
@@ -70,31 +102,132 @@ func (c *cache) GetCacheEntry(key CacheKey) *CacheEntry {
 	entry.wg.Done()
 
 	c.cacheLock.Lock()
-	// We allow the error to be handled by current waiters, but don't persist it
-	// for future callers.
+	// We allow the error to be handled by current waiters. Errors aren't
+	// persisted for future callers, unless the fetcher is configured with a
+	// NegativeTTL and the error is a tombstonable one, in which case we keep
+	// a zero-byte entry around so we don't keep re-fetching a known-missing
+	// resource.
 	if entry.Error != nil {
-		delete(c.cache, key)
+		if negativeTTL := c.negativeTTL(key, entry.Error); negativeTTL > 0 {
+			entry.Expiry = time.Now().Add(negativeTTL)
+			c.addDependents(key)
+		} else {
+			delete(c.cache, key)
+		}
 	} else {
 		size := int64(len(entry.Bytes))
 		c.cacheSizeExpVar.Add(size)
 		c.cacheSize += size
+		c.setExpiry(key, entry)
+		c.addDependents(key)
 	}
 	c.cacheLock.Unlock()
 
 	return entry
 }
 
+// addDependents records key against each of its declared dependencies in the
+// reverse index, so that invalidating a dependency can find key directly
+// instead of scanning the whole cache. Must be called with cacheLock held.
+func (c *cache) addDependents(key CacheKey) {
+	for _, dep := range key.Dependencies() {
+		deps, ok := c.dependents[dep]
+		if !ok {
+			deps = make(map[CacheKey]struct{})
+			c.dependents[dep] = deps
+		}
+		deps[key] = struct{}{}
+	}
+}
+
+// negativeTTL returns the NegativeTTL configured for key's fetcher, or zero
+// if none is configured or err isn't a tombstonable error.
+func (c *cache) negativeTTL(key CacheKey, err error) time.Duration {
+	if !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrGone) {
+		return 0
+	}
+	t := reflect.ValueOf(key).Type()
+	return c.fetcherOpts[t].NegativeTTL
+}
+
+// setExpiry stamps entry with its Expiry/staleAt times based on the
+// FetcherOptions registered for key's type. Must be called with cacheLock
+// held.
+func (c *cache) setExpiry(key CacheKey, entry *CacheEntry) {
+	t := reflect.ValueOf(key).Type()
+	opts, ok := c.fetcherOpts[t]
+	if !ok || opts.TTL <= 0 {
+		return
+	}
+	entry.staleAt = entry.Created.Add(opts.TTL)
+	entry.Expiry = entry.staleAt.Add(opts.StaleWhileRevalidate)
+}
+
+// refreshInBackground kicks off a single in-flight refetch of key, swapping
+// in a new *CacheEntry once it completes. Concurrent callers that observe
+// entry as already stale are no-ops, so only one refetch runs per stale
+// period. The stale entry is never mutated in place: callers that already
+// hold it (e.g. mid-Get) keep reading its fields undisturbed, since a new
+// entry object replaces it in c.cache rather than being written through it.
+func (c *cache) refreshInBackground(key CacheKey, entry *CacheEntry) {
+	if !atomic.CompareAndSwapInt32(&entry.refreshing, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&entry.refreshing, 0)
+
+		bytes, err := c.fetch(key)
+		if err != nil {
+			// Keep serving the stale entry; we'll try again next time it's
+			// found stale.
+			return
+		}
+
+		c.cacheLock.Lock()
+		defer c.cacheLock.Unlock()
+		if current, ok := c.cache[key]; !ok || current != entry {
+			// Entry was invalidated or replaced while we were fetching.
+			return
+		}
+		delta := int64(len(bytes)) - int64(len(entry.Bytes))
+		refreshed := &CacheEntry{
+			Key:      key,
+			Bytes:    bytes,
+			Created:  time.Now(),
+			Accessed: time.Now(),
+		}
+		c.setExpiry(key, refreshed)
+		c.cache[key] = refreshed
+		c.cacheSizeExpVar.Add(delta)
+		c.cacheSize += delta
+	}()
+}
+
 func (c *cache) Peek(key CacheKey) bool {
 	c.cacheLock.Lock()
 	if entry, ok := c.cache[key]; ok {
 		c.cacheLock.Unlock()
 		entry.wg.Wait()
+		if !entry.Expiry.IsZero() && time.Now().After(entry.Expiry) {
+			return false
+		}
 		return entry.Error == nil
 	}
 	c.cacheLock.Unlock()
 	return false
 }
 
+func (c *cache) PeekEntry(key CacheKey) *CacheEntry {
+	c.cacheLock.Lock()
+	entry, ok := c.cache[key]
+	c.cacheLock.Unlock()
+	if !ok {
+		return nil
+	}
+	entry.wg.Wait()
+	return entry
+}
+
 func (c *cache) Entries() []CacheEntry {
 	c.cacheLock.Lock()
 	defer c.cacheLock.Unlock()
@@ -116,28 +249,62 @@ func (c *cache) Size() int64 {
 }
 
 func (c *cache) invalidate(key CacheKey, recursive bool) bool {
-	if entry, ok := c.cache[key]; ok {
-		size := int64(len(entry.Bytes))
-		c.cacheSizeExpVar.Add(-size)
-		c.cacheSize -= size
-		delete(c.cache, key)
-		if recursive {
-			c.invalidateDependents(key)
-		}
-		return true
+	entry, ok := c.cache[key]
+	if !ok {
+		return false
 	}
-	return false
-}
 
-func (c *cache) invalidateDependents(key CacheKey) {
-	// TODO: this can be optimized.
-	for k, _ := range c.cache {
-		for _, dep := range k.Dependencies() {
-			if dep == key {
-				c.invalidate(k, true)
+	size := int64(len(entry.Bytes))
+	c.cacheSizeExpVar.Add(-size)
+	c.cacheSize -= size
+	delete(c.cache, key)
+
+	for _, dep := range key.Dependencies() {
+		if deps, ok := c.dependents[dep]; ok {
+			delete(deps, key)
+			if len(deps) == 0 {
+				delete(c.dependents, dep)
 			}
 		}
 	}
+
+	if recursive {
+		// Deleting the currently-visited or a not-yet-visited key from a map
+		// during range is well-defined in Go, so invalidating dependents as we
+		// go (which removes them from c.dependents[key]) is safe.
+		for dependent := range c.dependents[key] {
+			c.invalidate(dependent, true)
+		}
+		// Only clear key's own dependents bookkeeping once we've actually
+		// invalidated them above. A non-recursive invalidate leaves key's
+		// dependents cached, so c.dependents[key] must survive for a later
+		// recursive Invalidate to still find them.
+		delete(c.dependents, key)
+	}
+
+	return true
+}
+
+// dependentsOf returns the keys currently cached that directly depend on key,
+// i.e. those whose Dependencies() includes key. It's used by delegating
+// Cache implementations (e.g. lru, sieve) to drive their own recursive
+// invalidation without rescanning their own storage.
+func (c *cache) dependentsOf(key CacheKey) []CacheKey {
+	c.cacheLock.Lock()
+	defer c.cacheLock.Unlock()
+	deps := c.dependents[key]
+	keys := make([]CacheKey, 0, len(deps))
+	for k := range deps {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// dependentsLister is implemented by the base cache to expose its reverse
+// dependency index to delegating Cache implementations that need to drive
+// their own recursive invalidation.
+type dependentsLister interface {
+	dependentsOf(key CacheKey) []CacheKey
 }
 
 // fetch uses reflection to look up the right fetcher, then requests the data.
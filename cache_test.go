@@ -0,0 +1,147 @@
+// Copyright 2015 Daniel Pupius
+
+package rcache
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type cacheBaseKey struct {
+	id string
+}
+
+func (key cacheBaseKey) Dependencies() []CacheKey {
+	return NoDeps
+}
+
+type cacheDependentKey struct {
+	id string
+}
+
+func (key cacheDependentKey) Dependencies() []CacheKey {
+	return []CacheKey{cacheBaseKey{key.id}}
+}
+
+func TestNonRecursiveInvalidateKeepsDependentsIndex(t *testing.T) {
+	c := New("dependents1")
+	c.RegisterFetcher(func(key cacheBaseKey) ([]byte, error) {
+		return []byte("base:" + key.id), nil
+	})
+	c.RegisterFetcher(func(key cacheDependentKey) ([]byte, error) {
+		return []byte("dep:" + key.id), nil
+	})
+
+	c.Get(cacheBaseKey{"x"})
+	c.Get(cacheDependentKey{"x"})
+
+	// A non-recursive invalidate of the base shouldn't touch its dependent,
+	// nor should it forget that the dependent still depends on it.
+	c.Invalidate(cacheBaseKey{"x"}, false)
+	if !c.Peek(cacheDependentKey{"x"}) {
+		t.Fatal("expected dependent to still be cached")
+	}
+
+	c.Get(cacheBaseKey{"x"})
+
+	// A later recursive invalidate must still cascade to the dependent.
+	c.Invalidate(cacheBaseKey{"x"}, true)
+	if c.Peek(cacheDependentKey{"x"}) {
+		t.Error("expected recursive invalidate to reach the dependent even after an earlier non-recursive invalidate")
+	}
+}
+
+func TestNegativeCaching(t *testing.T) {
+	c := New("negttl1")
+
+	var calls int32
+	c.RegisterFetcherWithOptions(func(key StrKey) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		return []byte{}, ErrNotFound
+	}, FetcherOptions{NegativeTTL: 20 * time.Millisecond})
+
+	if _, err := c.Get(StrKey("missing")); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := c.Get(StrKey("missing")); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected fetcher to be called once while tombstoned, got %d calls", calls)
+	}
+
+	entry := c.PeekEntry(StrKey("missing"))
+	if entry == nil || entry.Error != ErrNotFound {
+		t.Error("expected PeekEntry to return the tombstoned entry")
+	}
+	if c.Peek(StrKey("missing")) {
+		t.Error("Peek should not report a tombstoned entry as a valid value")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	c.Get(StrKey("missing"))
+	if calls != 2 {
+		t.Errorf("expected fetcher to be retried after NegativeTTL, got %d calls", calls)
+	}
+
+	c.Invalidate(StrKey("missing"), false)
+	if c.PeekEntry(StrKey("missing")) != nil {
+		t.Error("expected Invalidate to remove the tombstone")
+	}
+}
+
+func TestFetcherTTLExpiry(t *testing.T) {
+	c := New("ttl1")
+	c.RegisterFetcherWithOptions(func(key StrKey) ([]byte, error) {
+		return []byte(key), nil
+	}, FetcherOptions{TTL: 10 * time.Millisecond})
+
+	bytes, _ := c.Get(StrKey("a"))
+	if string(bytes) != "a" {
+		t.Fatalf("expected %q, got %q", "a", bytes)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if c.Peek(StrKey("a")) {
+		t.Error("Expected entry to have expired")
+	}
+}
+
+func TestFetcherStaleWhileRevalidate(t *testing.T) {
+	c := New("ttl2")
+
+	var calls int32
+	c.RegisterFetcherWithOptions(func(key StrKey) ([]byte, error) {
+		n := atomic.AddInt32(&calls, 1)
+		return []byte(strings.Repeat(string(key), int(n))), nil
+	}, FetcherOptions{
+		TTL:                  10 * time.Millisecond,
+		StaleWhileRevalidate: 100 * time.Millisecond,
+	})
+
+	bytes, _ := c.Get(StrKey("a"))
+	if string(bytes) != "a" {
+		t.Fatalf("expected %q, got %q", "a", bytes)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	// Within the stale-while-revalidate window: the stale value is returned
+	// immediately and a background refresh is kicked off.
+	bytes, _ = c.Get(StrKey("a"))
+	if string(bytes) != "a" {
+		t.Fatalf("expected stale value %q, got %q", "a", bytes)
+	}
+
+	// Give the background refresh time to complete.
+	time.Sleep(20 * time.Millisecond)
+
+	bytes, _ = c.Get(StrKey("a"))
+	if string(bytes) != "aa" {
+		t.Fatalf("expected refreshed value %q, got %q", "aa", bytes)
+	}
+}
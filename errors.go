@@ -0,0 +1,16 @@
+// Copyright 2015 Daniel Pupius
+
+package rcache
+
+import "errors"
+
+// ErrNotFound is a sentinel a fetcher can return (directly or wrapped, see
+// errors.Is) to indicate the resource doesn't exist. When the fetcher's type
+// was registered with a NegativeTTL, the cache persists a tombstone entry for
+// the key instead of forgetting it, so repeated lookups for a missing
+// resource don't hammer the fetcher.
+var ErrNotFound = errors.New("rcache: not found")
+
+// ErrGone is like ErrNotFound but for resources that used to exist. It is
+// tombstoned the same way.
+var ErrGone = errors.New("rcache: gone")
@@ -0,0 +1,151 @@
+// Copyright 2015 Daniel Pupius
+
+package rcache
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// fsStore is a filesystem-backed Store, used as the default L2 for a Tiered
+// cache. Keys are hashed to a two-level directory layout so a single
+// directory never holds too many entries, and the store enforces its own
+// maxBytes cap by evicting the least recently used files on disk.
+type fsStore struct {
+	dir      string
+	maxBytes int64
+
+	mu   sync.Mutex
+	size int64
+}
+
+// NewFSStore returns a Store that persists values under dir, evicting the
+// least recently used files once the store exceeds maxBytes.
+func NewFSStore(dir string, maxBytes int64) Store {
+	os.MkdirAll(dir, 0755)
+	s := &fsStore{dir: dir, maxBytes: maxBytes}
+	s.size = s.scanSize()
+	return s
+}
+
+func (s *fsStore) pathFor(key string) string {
+	sum := sha1.Sum([]byte(key))
+	hexSum := hex.EncodeToString(sum[:])
+	return filepath.Join(s.dir, hexSum[:2], hexSum)
+}
+
+func (s *fsStore) Get(key string) ([]byte, bool, error) {
+	b, err := os.ReadFile(s.pathFor(key))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	// Bump mtime so this entry looks recently used for LRU-on-disk eviction.
+	now := time.Now()
+	os.Chtimes(s.pathFor(key), now, now)
+	return b, true, nil
+}
+
+func (s *fsStore) Put(key string, value []byte) error {
+	path := s.pathFor(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if fi, err := os.Stat(path); err == nil {
+		s.size -= fi.Size()
+	}
+	if err := os.WriteFile(path, value, 0644); err != nil {
+		return err
+	}
+	s.size += int64(len(value))
+
+	return s.evictLocked()
+}
+
+func (s *fsStore) Delete(key string) error {
+	path := s.pathFor(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if fi, err := os.Stat(path); err == nil {
+		s.size -= fi.Size()
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *fsStore) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// scanSize walks dir to compute the store's starting size, so a restarted
+// process picks up where an earlier one left off.
+func (s *fsStore) scanSize() int64 {
+	var total int64
+	filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// evictLocked removes the least recently used files until the store is back
+// under maxBytes. Callers must hold s.mu.
+func (s *fsStore) evictLocked() error {
+	if s.maxBytes <= 0 {
+		return nil
+	}
+	for s.size > s.maxBytes {
+		path, size, ok := s.oldest()
+		if !ok {
+			break
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		s.size -= size
+	}
+	return nil
+}
+
+// oldest returns the path and size of the least recently modified file
+// under dir.
+func (s *fsStore) oldest() (string, int64, bool) {
+	type candidate struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var candidates []candidate
+	filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			candidates = append(candidates, candidate{path, info.Size(), info.ModTime()})
+		}
+		return nil
+	})
+	if len(candidates) == 0 {
+		return "", 0, false
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].modTime.Before(candidates[j].modTime)
+	})
+	return candidates[0].path, candidates[0].size, true
+}
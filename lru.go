@@ -13,23 +13,31 @@ type lru struct {
 	mu           sync.Mutex
 	elementMap   map[CacheKey]*list.Element
 	elementList  *list.List // least recently used at the front.
+	metrics      Metrics
 }
 
 // NewLRU returns a cache with a max byte size. Least recently used entries will
 // be evicted first.
-func NewLRU(name string, maxSizeBytes int64) Cache {
-	return &lru{
+func NewLRU(name string, maxSizeBytes int64, opts ...Option) Cache {
+	co := newCacheOptions(opts)
+	l := &lru{
 		maxSizeBytes: maxSizeBytes,
 		delegate:     New(name),
 		elementMap:   make(map[CacheKey]*list.Element),
 		elementList:  list.New(),
+		metrics:      co.metrics,
 	}
+	return wrapMiddleware(l, co.middleware)
 }
 
 func (l *lru) RegisterFetcher(fn interface{}) {
 	l.delegate.RegisterFetcher(fn)
 }
 
+func (l *lru) RegisterFetcherWithOptions(fn interface{}, opts FetcherOptions) {
+	l.delegate.RegisterFetcherWithOptions(fn, opts)
+}
+
 func (l *lru) Entries() []CacheEntry {
 	// TODO(dan): Return copy that reflects LRU ordering.
 	return l.delegate.Entries()
@@ -42,25 +50,46 @@ func (l *lru) Size() int64 {
 func (l *lru) Invalidate(key CacheKey, recursive bool) bool {
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	// TODO(dan): recursive invalidation of LRU doesn't work.
-	if ok := l.delegate.Invalidate(key, false); ok {
-		l.elementList.Remove(l.elementMap[key])
-		delete(l.elementMap, key)
-		return true
+	return l.invalidate(key, recursive)
+}
+
+// invalidate removes key from the LRU, and if recursive is true, also removes
+// any entries that depend on it. Dependents are looked up on the delegate
+// before it forgets about key, since invalidating key there also drops it
+// from the delegate's reverse dependency index. Must be called with mu held.
+func (l *lru) invalidate(key CacheKey, recursive bool) bool {
+	var dependents []CacheKey
+	if recursive {
+		if dl, ok := l.delegate.(dependentsLister); ok {
+			dependents = dl.dependentsOf(key)
+		}
+	}
+
+	ok := l.delegate.Invalidate(key, false)
+	if ok {
+		if element, found := l.elementMap[key]; found {
+			l.elementList.Remove(element)
+			delete(l.elementMap, key)
+		}
+	}
+
+	for _, dep := range dependents {
+		l.invalidate(dep, true)
 	}
-	return false
+
+	return ok
 }
 
 func (l *lru) Peek(key CacheKey) bool {
 	return l.delegate.Peek(key)
 }
 
-func (l *lru) GetCacheEntry(key CacheKey) *CacheEntry {
-	return l.delegate.GetCacheEntry(key)
+func (l *lru) PeekEntry(key CacheKey) *CacheEntry {
+	return l.delegate.PeekEntry(key)
 }
 
-func (l *lru) Get(key CacheKey) ([]byte, error) {
-	e := l.GetCacheEntry(key)
+func (l *lru) GetCacheEntry(key CacheKey) *CacheEntry {
+	e := l.delegate.GetCacheEntry(key)
 
 	if e.Error == nil {
 		l.mu.Lock()
@@ -76,9 +105,17 @@ func (l *lru) Get(key CacheKey) ([]byte, error) {
 			key := l.elementList.Remove(f).(CacheKey)
 			delete(l.elementMap, key)
 			l.delegate.Invalidate(key, false)
+			if l.metrics != nil {
+				l.metrics.OnEvict(key)
+			}
 		}
 		l.mu.Unlock()
 	}
 
+	return e
+}
+
+func (l *lru) Get(key CacheKey) ([]byte, error) {
+	e := l.GetCacheEntry(key)
 	return e.Bytes, e.Error
 }
@@ -0,0 +1,32 @@
+// Copyright 2015 Daniel Pupius
+
+package rcache
+
+import "time"
+
+// Metrics receives notifications about cache activity. Implementations must
+// be safe for concurrent use, and hooks should not block since they're
+// invoked on the hot path. See WithMetrics and the rcache/promcache
+// subpackage for a ready-made Prometheus-backed implementation.
+type Metrics interface {
+	// OnHit is called when Get/GetCacheEntry finds a valid, unexpired entry.
+	OnHit(key CacheKey)
+
+	// OnMiss is called when Get/GetCacheEntry has to invoke the fetcher.
+	OnMiss(key CacheKey)
+
+	// OnFetchError is called when a fetcher returns an error.
+	OnFetchError(key CacheKey, err error)
+
+	// OnEvict is called when an entry is evicted to make room for another,
+	// e.g. by NewLRU or NewSIEVE. Implementations without a built-in
+	// eviction strategy never call this.
+	OnEvict(key CacheKey)
+
+	// OnInvalidate is called when Invalidate removes an entry.
+	OnInvalidate(key CacheKey)
+
+	// OnFetchLatency is called with how long a fetcher took to run, after a
+	// cache miss.
+	OnFetchLatency(key CacheKey, d time.Duration)
+}
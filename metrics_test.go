@@ -0,0 +1,89 @@
+// Copyright 2015 Daniel Pupius
+
+package rcache
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingMetrics struct {
+	hits, misses, errors, evictions, invalidations int
+}
+
+func (m *countingMetrics) OnHit(key CacheKey)                           { m.hits++ }
+func (m *countingMetrics) OnMiss(key CacheKey)                          { m.misses++ }
+func (m *countingMetrics) OnFetchError(key CacheKey, err error)         { m.errors++ }
+func (m *countingMetrics) OnEvict(key CacheKey)                         { m.evictions++ }
+func (m *countingMetrics) OnInvalidate(key CacheKey)                    { m.invalidations++ }
+func (m *countingMetrics) OnFetchLatency(key CacheKey, d time.Duration) {}
+
+func TestWithMetricsReportsHitsAndMisses(t *testing.T) {
+	m := &countingMetrics{}
+	c := New("metrics1", WithMetrics(m))
+	c.RegisterFetcher(func(key StrKey) ([]byte, error) {
+		return []byte(key), nil
+	})
+
+	c.Get(StrKey("a"))
+	c.Get(StrKey("a"))
+
+	if m.misses != 1 {
+		t.Errorf("expected 1 miss, got %d", m.misses)
+	}
+	if m.hits != 1 {
+		t.Errorf("expected 1 hit, got %d", m.hits)
+	}
+
+	c.Invalidate(StrKey("a"), false)
+	if m.invalidations != 1 {
+		t.Errorf("expected 1 invalidation, got %d", m.invalidations)
+	}
+}
+
+func TestWithMetricsReportsEvictions(t *testing.T) {
+	m := &countingMetrics{}
+	lru := NewLRU("metrics2", 2, WithMetrics(m))
+	lru.RegisterFetcher(func(key FixedSizeEntry) ([]byte, error) {
+		return make([]byte, key.size), nil
+	})
+
+	lru.Get(FixedSizeEntry{1})
+	lru.Get(FixedSizeEntry{2}) // Evicts the first entry, size would be 3.
+
+	if m.evictions != 1 {
+		t.Errorf("expected 1 eviction, got %d", m.evictions)
+	}
+}
+
+func TestWithMetricsReportsTombstoneHitsNotMisses(t *testing.T) {
+	m := &countingMetrics{}
+	c := New("metrics3", WithMetrics(m))
+
+	var calls int32
+	c.RegisterFetcherWithOptions(func(key StrKey) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		return []byte{}, ErrNotFound
+	}, FetcherOptions{NegativeTTL: time.Minute})
+
+	for i := 0; i < 5; i++ {
+		c.Get(StrKey("missing"))
+	}
+
+	if calls != 1 {
+		t.Errorf("expected fetcher to be called once, got %d calls", calls)
+	}
+	// Only the very first Get actually invoked the fetcher; the rest were
+	// served from the tombstone and should count as hits, not repeated
+	// misses/errors.
+	if m.misses != 1 {
+		t.Errorf("expected 1 miss, got %d", m.misses)
+	}
+	if m.errors != 1 {
+		t.Errorf("expected 1 fetch error, got %d", m.errors)
+	}
+	if m.hits != 4 {
+		t.Errorf("expected 4 hits, got %d", m.hits)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2015 Daniel Pupius
+
+package rcache
+
+import "time"
+
+// Middleware wraps a Cache to add cross-cutting behavior, such as metrics,
+// without the wrapped Cache needing to know about it. It lets features
+// compose by stacking rather than by each constructor growing its own ad
+// hoc wrapper; see WithMetrics for the built-in example. Eviction
+// strategies (NewLRU, NewSIEVE) remain dedicated Cache implementations,
+// since eviction needs access to internals a Middleware can't see.
+type Middleware func(Cache) Cache
+
+// wrapMiddleware applies each Middleware in mws to c, in order, returning
+// the outermost wrapper.
+func wrapMiddleware(c Cache, mws []Middleware) Cache {
+	for _, mw := range mws {
+		c = mw(c)
+	}
+	return c
+}
+
+// metricsMiddleware reports Get/Peek/Invalidate activity to m. It wraps any
+// Cache implementation generically; eviction-aware implementations also
+// report OnEvict directly, since eviction happens below this layer.
+func metricsMiddleware(m Metrics) Middleware {
+	return func(c Cache) Cache {
+		return &metricsCache{delegate: c, metrics: m}
+	}
+}
+
+type metricsCache struct {
+	delegate Cache
+	metrics  Metrics
+}
+
+func (m *metricsCache) RegisterFetcher(fn interface{}) {
+	m.delegate.RegisterFetcher(fn)
+}
+
+func (m *metricsCache) RegisterFetcherWithOptions(fn interface{}, opts FetcherOptions) {
+	m.delegate.RegisterFetcherWithOptions(fn, opts)
+}
+
+func (m *metricsCache) Get(key CacheKey) ([]byte, error) {
+	e := m.GetCacheEntry(key)
+	return e.Bytes, e.Error
+}
+
+func (m *metricsCache) GetCacheEntry(key CacheKey) *CacheEntry {
+	// Peek(key) can't tell us whether the fetcher actually ran: it reports a
+	// tombstoned negative-cache entry as "not cached" even though repeated
+	// Gets within its NegativeTTL are served without invoking the fetcher.
+	// Compare entry identity before and after instead — GetCacheEntry swaps
+	// in a new *CacheEntry only when it actually fetched, tombstoned or not.
+	before := m.delegate.PeekEntry(key)
+	start := time.Now()
+	entry := m.delegate.GetCacheEntry(key)
+
+	if before != nil && before == entry {
+		m.metrics.OnHit(key)
+		return entry
+	}
+
+	m.metrics.OnMiss(key)
+	m.metrics.OnFetchLatency(key, time.Since(start))
+	if entry.Error != nil {
+		m.metrics.OnFetchError(key, entry.Error)
+	}
+	return entry
+}
+
+func (m *metricsCache) Peek(key CacheKey) bool {
+	return m.delegate.Peek(key)
+}
+
+func (m *metricsCache) PeekEntry(key CacheKey) *CacheEntry {
+	return m.delegate.PeekEntry(key)
+}
+
+func (m *metricsCache) Entries() []CacheEntry {
+	return m.delegate.Entries()
+}
+
+func (m *metricsCache) Invalidate(key CacheKey, recursive bool) bool {
+	ok := m.delegate.Invalidate(key, recursive)
+	if ok {
+		m.metrics.OnInvalidate(key)
+	}
+	return ok
+}
+
+func (m *metricsCache) Size() int64 {
+	return m.delegate.Size()
+}
@@ -0,0 +1,38 @@
+// Copyright 2015 Daniel Pupius
+
+package rcache
+
+// Option configures optional behavior when constructing a Cache.
+type Option func(*cacheOptions)
+
+type cacheOptions struct {
+	metrics    Metrics
+	middleware []Middleware
+}
+
+func newCacheOptions(opts []Option) cacheOptions {
+	var co cacheOptions
+	for _, opt := range opts {
+		opt(&co)
+	}
+	return co
+}
+
+// WithMetrics instruments a cache with m. Hits, misses, fetch errors,
+// invalidations, and fetch latency are reported via a Middleware that wraps
+// the constructed cache; eviction-aware constructors (NewLRU, NewSIEVE)
+// additionally report OnEvict directly.
+func WithMetrics(m Metrics) Option {
+	return func(co *cacheOptions) {
+		co.metrics = m
+		co.middleware = append(co.middleware, metricsMiddleware(m))
+	}
+}
+
+// WithMiddleware appends mw to a cache's construction. Middleware compose in
+// the order given: the first one wraps the cache innermost.
+func WithMiddleware(mw Middleware) Option {
+	return func(co *cacheOptions) {
+		co.middleware = append(co.middleware, mw)
+	}
+}
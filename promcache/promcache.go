@@ -0,0 +1,90 @@
+// Copyright 2015 Daniel Pupius
+
+// Package promcache provides a Prometheus-backed implementation of
+// rcache.Metrics, exporting cache activity as counters and a fetch-latency
+// histogram, labeled by cache name and fetcher key type.
+package promcache
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/dpup/rcache"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics implements rcache.Metrics, suitable for passing to
+// rcache.WithMetrics.
+type Metrics struct {
+	name          string
+	hits          *prometheus.CounterVec
+	misses        *prometheus.CounterVec
+	fetchErrors   *prometheus.CounterVec
+	evictions     *prometheus.CounterVec
+	invalidations *prometheus.CounterVec
+	fetchLatency  *prometheus.HistogramVec
+}
+
+// New registers and returns Metrics for a cache called name. The labels
+// "cache" and "fetcher" are applied to every series, identifying the cache
+// instance and the registered fetcher's key type respectively.
+func New(reg prometheus.Registerer, name string) *Metrics {
+	labels := []string{"cache", "fetcher"}
+	m := &Metrics{
+		name: name,
+		hits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rcache_hits_total",
+			Help: "Number of cache hits.",
+		}, labels),
+		misses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rcache_misses_total",
+			Help: "Number of cache misses.",
+		}, labels),
+		fetchErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rcache_fetch_errors_total",
+			Help: "Number of fetcher errors.",
+		}, labels),
+		evictions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rcache_evictions_total",
+			Help: "Number of entries evicted to make room for another.",
+		}, labels),
+		invalidations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rcache_invalidations_total",
+			Help: "Number of entries explicitly invalidated.",
+		}, labels),
+		fetchLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "rcache_fetch_latency_seconds",
+			Help: "Latency of fetcher calls made on a cache miss.",
+		}, labels),
+	}
+	reg.MustRegister(m.hits, m.misses, m.fetchErrors, m.evictions, m.invalidations, m.fetchLatency)
+	return m
+}
+
+func (m *Metrics) fetcherLabel(key rcache.CacheKey) string {
+	return reflect.TypeOf(key).String()
+}
+
+func (m *Metrics) OnHit(key rcache.CacheKey) {
+	m.hits.WithLabelValues(m.name, m.fetcherLabel(key)).Inc()
+}
+
+func (m *Metrics) OnMiss(key rcache.CacheKey) {
+	m.misses.WithLabelValues(m.name, m.fetcherLabel(key)).Inc()
+}
+
+func (m *Metrics) OnFetchError(key rcache.CacheKey, err error) {
+	m.fetchErrors.WithLabelValues(m.name, m.fetcherLabel(key)).Inc()
+}
+
+func (m *Metrics) OnEvict(key rcache.CacheKey) {
+	m.evictions.WithLabelValues(m.name, m.fetcherLabel(key)).Inc()
+}
+
+func (m *Metrics) OnInvalidate(key rcache.CacheKey) {
+	m.invalidations.WithLabelValues(m.name, m.fetcherLabel(key)).Inc()
+}
+
+func (m *Metrics) OnFetchLatency(key rcache.CacheKey, d time.Duration) {
+	m.fetchLatency.WithLabelValues(m.name, m.fetcherLabel(key)).Observe(d.Seconds())
+}
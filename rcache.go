@@ -29,21 +29,33 @@ type Cache interface {
 	// The return value should be ([]byte error).
 	RegisterFetcher(fn interface{})
 
+	// RegisterFetcherWithOptions is the same as RegisterFetcher but allows TTL
+	// and background refresh behavior to be configured for the fetcher's key
+	// type, see FetcherOptions.
+	RegisterFetcherWithOptions(fn interface{}, opts FetcherOptions)
+
 	// Get returns the data for a key, falling back to a fetcher function if the
 	// data hasn't yet been loaded. Concurrent callers will multiplex to the same
 	// fetcher.
-	Get(key interface{}) ([]byte, error)
+	Get(key CacheKey) ([]byte, error)
 
 	// GetCacheEntry is the same as Get but returns the meta cache entry.
-	GetCacheEntry(key interface{}) *CacheEntry
+	GetCacheEntry(key CacheKey) *CacheEntry
 
 	// Peek returns true if the key is currently cached. If the key is in the
 	// process of being fetched, Peek will block and return true on success.
-	Peek(key interface{}) bool
+	Peek(key CacheKey) bool
+
+	// PeekEntry returns the raw entry for a key if one is cached (including
+	// tombstoned negative-cache entries), or nil otherwise. If the key is in
+	// the process of being fetched, PeekEntry blocks until it completes.
+	// Unlike Peek it doesn't collapse the entry down to a bool, so callers
+	// can distinguish a valid value from a cached fetcher error.
+	PeekEntry(key CacheKey) *CacheEntry
 
 	// Invalidate removes an entry, and if `recursive` is true any entries that
 	// depend on it, from the cache.
-	Invalidate(key interface{}, recursive bool) bool
+	Invalidate(key CacheKey, recursive bool) bool
 
 	// Entries returns an array of entries currently in the cache.
 	Entries() []CacheEntry
@@ -55,17 +67,42 @@ type Cache interface {
 // CacheEntry stores details about an entry in the cache, including the content,
 // when it was created, and when it was last accessed.
 type CacheEntry struct {
-	Key      interface{}
+	Key      CacheKey
 	Bytes    []byte
 	Created  time.Time
 	Accessed time.Time
 	Error    error
-	wg       sync.WaitGroup
+
+	// Expiry is the time at which the entry must be treated as a miss,
+	// including any StaleWhileRevalidate window. It is the zero Time if the
+	// fetcher was registered without a TTL.
+	Expiry time.Time
+
+	// staleAt is when the entry becomes eligible for a background refresh,
+	// i.e. Created+TTL. It is only meaningful when Expiry is set.
+	staleAt time.Time
+
+	// refreshing is 1 while a background refresh for this entry is in
+	// flight, used to ensure only one refetch is started per stale period.
+	refreshing int32
+
+	wg sync.WaitGroup
 }
 
-// CacheKey is an interface that compound keys can implement in order to declare
-// dependent keys that should be invalidated.
-type CacheKey interface {
-	// Dependencies returns an array of cache keys that the key is dependent on.
-	Dependencies() []interface{}
+// FetcherOptions configures TTL and background refresh behavior for a
+// fetcher registered with RegisterFetcherWithOptions.
+type FetcherOptions struct {
+	// TTL is how long an entry is considered fresh after creation. Zero
+	// means entries never expire.
+	TTL time.Duration
+
+	// StaleWhileRevalidate extends an expired entry's life by this long: a
+	// Get within the window returns the stale bytes immediately and kicks
+	// off a single background refetch, rather than blocking callers.
+	StaleWhileRevalidate time.Duration
+
+	// NegativeTTL is how long a fetcher error is cached for before the
+	// fetcher is retried, see RegisterFetcherWithOptions and ErrNotFound.
+	NegativeTTL time.Duration
 }
+
@@ -0,0 +1,407 @@
+// Copyright 2015 Daniel Pupius
+
+package rcache
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultShards is the number of shards used by NewSharded when no explicit
+// count is given.
+const DefaultShards = 256
+
+// Hasher may be implemented by a CacheKey to provide a fast, stable hash
+// used for shard routing. Keys that don't implement Hasher fall back to a
+// reflection-based hash of their exported fields.
+type Hasher interface {
+	CacheHash() uint64
+}
+
+// shard owns a slice of the overall key space, each with its own lock so
+// that unrelated keys don't contend with each other.
+type shard struct {
+	mu    sync.RWMutex
+	cache map[CacheKey]*CacheEntry
+}
+
+// sharded is a Cache implementation that partitions entries across N
+// independently-locked shards, removing the single global mutex that
+// serializes every Get/Peek/Invalidate in the base cache implementation.
+type sharded struct {
+	fetchers        map[reflect.Type]reflect.Value
+	fetcherOpts     map[reflect.Type]FetcherOptions
+	fetchersLock    sync.RWMutex
+	shards          []*shard
+	cacheSize       int64 // accessed atomically
+	cacheSizeExpVar *expvar.Int
+
+	// dependents is a reverse index: dependents[k] holds the set of cached
+	// keys whose Dependencies() include k, mirroring cache.dependents. It's
+	// guarded by dependentsLock rather than a per-shard lock since a key and
+	// its dependents can land in different shards.
+	dependents     map[CacheKey]map[CacheKey]struct{}
+	dependentsLock sync.Mutex
+}
+
+// NewSharded returns a new cache with no built-in eviction strategy, whose
+// storage is partitioned across the given number of shards. If shards is
+// <= 0, DefaultShards is used. The cache's name is exposed with stats in
+// expvar.
+func NewSharded(name string, shards int, opts ...Option) Cache {
+	if shards <= 0 {
+		shards = DefaultShards
+	}
+	co := newCacheOptions(opts)
+	s := &sharded{
+		fetchers:        make(map[reflect.Type]reflect.Value),
+		fetcherOpts:     make(map[reflect.Type]FetcherOptions),
+		shards:          make([]*shard, shards),
+		cacheSizeExpVar: expvar.NewInt(fmt.Sprintf("cacheSize (%s)", name)),
+		dependents:      make(map[CacheKey]map[CacheKey]struct{}),
+	}
+	for i := range s.shards {
+		s.shards[i] = &shard{cache: make(map[CacheKey]*CacheEntry)}
+	}
+	return wrapMiddleware(s, co.middleware)
+}
+
+func (s *sharded) RegisterFetcher(fn interface{}) {
+	s.RegisterFetcherWithOptions(fn, FetcherOptions{})
+}
+
+func (s *sharded) RegisterFetcherWithOptions(fn interface{}, opts FetcherOptions) {
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+	assertValidFetcher(t)
+
+	s.fetchersLock.Lock()
+	defer s.fetchersLock.Unlock()
+
+	arg := t.In(0)
+	s.fetchers[arg] = v
+	s.fetcherOpts[arg] = opts
+}
+
+func (s *sharded) shardFor(key CacheKey) *shard {
+	return s.shards[hashKey(key)%uint64(len(s.shards))]
+}
+
+func (s *sharded) Get(key CacheKey) ([]byte, error) {
+	e := s.GetCacheEntry(key)
+	return e.Bytes, e.Error
+}
+
+func (s *sharded) GetCacheEntry(key CacheKey) *CacheEntry {
+	sh := s.shardFor(key)
+
+	sh.mu.Lock()
+	if entry, ok := sh.cache[key]; ok {
+		sh.mu.Unlock()
+		entry.wg.Wait()
+
+		if !entry.Expiry.IsZero() && time.Now().After(entry.Expiry) {
+			// Past TTL, including any stale-while-revalidate window: treat
+			// this as a miss rather than serving stale data forever.
+			sh.mu.Lock()
+			if sh.cache[key] == entry {
+				s.invalidate(sh, key)
+			}
+			sh.mu.Unlock()
+			return s.GetCacheEntry(key)
+		}
+
+		entry.Accessed = time.Now()
+		if !entry.staleAt.IsZero() && time.Now().After(entry.staleAt) {
+			s.refreshInBackground(sh, key, entry)
+		}
+		return entry
+	}
+
+	// Create the cache entry for future callers to wait on.
+	entry := &CacheEntry{Key: key, Created: time.Now(), Accessed: time.Now()}
+	entry.wg.Add(1)
+	sh.cache[key] = entry
+	sh.mu.Unlock()
+
+	entry.Bytes, entry.Error = s.fetch(key)
+	entry.wg.Done()
+
+	sh.mu.Lock()
+	// We allow the error to be handled by current waiters. Errors aren't
+	// persisted for future callers, unless the fetcher is configured with a
+	// NegativeTTL and the error is a tombstonable one.
+	if entry.Error != nil {
+		if negativeTTL := s.negativeTTL(key, entry.Error); negativeTTL > 0 {
+			entry.Expiry = time.Now().Add(negativeTTL)
+			s.addDependents(key)
+		} else {
+			delete(sh.cache, key)
+		}
+	} else {
+		size := int64(len(entry.Bytes))
+		s.cacheSizeExpVar.Add(size)
+		atomic.AddInt64(&s.cacheSize, size)
+		s.setExpiry(key, entry)
+		s.addDependents(key)
+	}
+	sh.mu.Unlock()
+
+	return entry
+}
+
+// addDependents records key against each of its declared dependencies in the
+// reverse index, so that invalidating a dependency can find key directly
+// instead of scanning every shard.
+func (s *sharded) addDependents(key CacheKey) {
+	s.dependentsLock.Lock()
+	defer s.dependentsLock.Unlock()
+	for _, dep := range key.Dependencies() {
+		deps, ok := s.dependents[dep]
+		if !ok {
+			deps = make(map[CacheKey]struct{})
+			s.dependents[dep] = deps
+		}
+		deps[key] = struct{}{}
+	}
+}
+
+// negativeTTL returns the NegativeTTL configured for key's fetcher, or zero
+// if none is configured or err isn't a tombstonable error.
+func (s *sharded) negativeTTL(key CacheKey, err error) time.Duration {
+	if !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrGone) {
+		return 0
+	}
+	t := reflect.ValueOf(key).Type()
+	s.fetchersLock.RLock()
+	defer s.fetchersLock.RUnlock()
+	return s.fetcherOpts[t].NegativeTTL
+}
+
+func (s *sharded) PeekEntry(key CacheKey) *CacheEntry {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	entry, ok := sh.cache[key]
+	sh.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	entry.wg.Wait()
+	return entry
+}
+
+// setExpiry stamps entry with its Expiry/staleAt times based on the
+// FetcherOptions registered for key's type. Must be called with sh.mu held.
+func (s *sharded) setExpiry(key CacheKey, entry *CacheEntry) {
+	t := reflect.ValueOf(key).Type()
+	s.fetchersLock.RLock()
+	opts, ok := s.fetcherOpts[t]
+	s.fetchersLock.RUnlock()
+	if !ok || opts.TTL <= 0 {
+		return
+	}
+	entry.staleAt = entry.Created.Add(opts.TTL)
+	entry.Expiry = entry.staleAt.Add(opts.StaleWhileRevalidate)
+}
+
+// refreshInBackground kicks off a single in-flight refetch of key, swapping
+// in a new *CacheEntry once it completes, mirroring the base cache's
+// behavior. The stale entry is never mutated in place, since callers may
+// already hold its pointer with no synchronization of their own.
+func (s *sharded) refreshInBackground(sh *shard, key CacheKey, entry *CacheEntry) {
+	if !atomic.CompareAndSwapInt32(&entry.refreshing, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&entry.refreshing, 0)
+
+		bytes, err := s.fetch(key)
+		if err != nil {
+			return
+		}
+
+		sh.mu.Lock()
+		defer sh.mu.Unlock()
+		if current, ok := sh.cache[key]; !ok || current != entry {
+			return
+		}
+		delta := int64(len(bytes)) - int64(len(entry.Bytes))
+		refreshed := &CacheEntry{
+			Key:      key,
+			Bytes:    bytes,
+			Created:  time.Now(),
+			Accessed: time.Now(),
+		}
+		s.setExpiry(key, refreshed)
+		sh.cache[key] = refreshed
+		s.cacheSizeExpVar.Add(delta)
+		atomic.AddInt64(&s.cacheSize, delta)
+	}()
+}
+
+func (s *sharded) Peek(key CacheKey) bool {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	entry, ok := sh.cache[key]
+	sh.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	entry.wg.Wait()
+	if !entry.Expiry.IsZero() && time.Now().After(entry.Expiry) {
+		return false
+	}
+	return entry.Error == nil
+}
+
+func (s *sharded) Entries() []CacheEntry {
+	entries := make([]CacheEntry, 0)
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		for _, v := range sh.cache {
+			entries = append(entries, *v)
+		}
+		sh.mu.RUnlock()
+	}
+	return entries
+}
+
+func (s *sharded) Invalidate(key CacheKey, recursive bool) bool {
+	sh := s.shardFor(key)
+	sh.mu.Lock()
+	ok := s.invalidate(sh, key)
+	sh.mu.Unlock()
+	if ok && recursive {
+		s.invalidateDependents(key)
+	}
+	return ok
+}
+
+func (s *sharded) Size() int64 {
+	return atomic.LoadInt64(&s.cacheSize)
+}
+
+// invalidate removes key from sh, which must already be locked for writing.
+func (s *sharded) invalidate(sh *shard, key CacheKey) bool {
+	entry, ok := sh.cache[key]
+	if !ok {
+		return false
+	}
+	size := int64(len(entry.Bytes))
+	s.cacheSizeExpVar.Add(-size)
+	atomic.AddInt64(&s.cacheSize, -size)
+	delete(sh.cache, key)
+
+	s.dependentsLock.Lock()
+	for _, dep := range key.Dependencies() {
+		if deps, ok := s.dependents[dep]; ok {
+			delete(deps, key)
+			if len(deps) == 0 {
+				delete(s.dependents, dep)
+			}
+		}
+	}
+	s.dependentsLock.Unlock()
+
+	return true
+}
+
+// invalidateDependents looks up key's dependents in the reverse index and
+// invalidates (recursively) each one, across whichever shard it landed in.
+func (s *sharded) invalidateDependents(key CacheKey) {
+	for _, dependent := range s.dependentsOf(key) {
+		sh := s.shardFor(dependent)
+		sh.mu.Lock()
+		ok := s.invalidate(sh, dependent)
+		sh.mu.Unlock()
+		if ok {
+			s.invalidateDependents(dependent)
+		}
+	}
+	s.dependentsLock.Lock()
+	delete(s.dependents, key)
+	s.dependentsLock.Unlock()
+}
+
+// dependentsOf returns the keys currently cached that directly depend on key.
+func (s *sharded) dependentsOf(key CacheKey) []CacheKey {
+	s.dependentsLock.Lock()
+	defer s.dependentsLock.Unlock()
+	deps := s.dependents[key]
+	keys := make([]CacheKey, 0, len(deps))
+	for k := range deps {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// fetch uses reflection to look up the right fetcher, then requests the data.
+func (s *sharded) fetch(key CacheKey) ([]byte, error) {
+	v := reflect.ValueOf(key)
+	t := v.Type()
+	if fetcher, ok := s.fetchers[t]; ok {
+		values := fetcher.Call([]reflect.Value{v})
+		// We've already verified types should be correct.
+		if values[1].Interface() != nil {
+			return []byte{}, values[1].Interface().(error)
+		}
+		return values[0].Bytes(), nil
+	}
+	panic(fmt.Sprintf("cache: No fetcher function for type [%v]", t))
+}
+
+// hashKey returns a stable hash for a CacheKey, used to pick its shard. Keys
+// that implement Hasher provide their own; everything else is hashed by
+// walking its exported fields with reflection.
+func hashKey(key CacheKey) uint64 {
+	if h, ok := key.(Hasher); ok {
+		return h.CacheHash()
+	}
+	h := fnv.New64a()
+	hashValue(h, reflect.ValueOf(key))
+	return h.Sum64()
+}
+
+// serializeKey returns a canonical string form of key, suitable for use with
+// a Store. It's derived from the same reflection-based hash used for shard
+// routing, but unlike a shard lookup - where a hash collision just routes
+// two different keys to the same shard, which still disambiguates them by
+// the full CacheKey - a Store has no such backstop: the string it returns is
+// the *only* identity a persisted value is addressed by. It's prefixed with
+// key's concrete type's full import path and name (not reflect.Type.String,
+// which only prints the short package name and isn't guaranteed unique) so
+// that two different CacheKey types can never collide with each other; a
+// hash collision between two keys of the same type is still possible in
+// principle, same as for shard routing.
+func serializeKey(key CacheKey) string {
+	t := reflect.ValueOf(key).Type()
+	return t.PkgPath() + "." + t.Name() + ":" + strconv.FormatUint(hashKey(key), 16)
+}
+
+func hashValue(h hash.Hash64, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+		hashValue(h, v.Elem())
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath != "" {
+				continue // unexported, skip.
+			}
+			hashValue(h, v.Field(i))
+		}
+	default:
+		fmt.Fprintf(h, "%v", v.Interface())
+	}
+}
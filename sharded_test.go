@@ -0,0 +1,125 @@
+// Copyright 2015 Daniel Pupius
+
+package rcache
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShardedFetcherTTLExpiry(t *testing.T) {
+	c := NewSharded("sharded-ttl1", 4)
+	c.RegisterFetcherWithOptions(func(key StrKey) ([]byte, error) {
+		return []byte(key), nil
+	}, FetcherOptions{TTL: 10 * time.Millisecond})
+
+	bytes, _ := c.Get(StrKey("a"))
+	if string(bytes) != "a" {
+		t.Fatalf("expected %q, got %q", "a", bytes)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if c.Peek(StrKey("a")) {
+		t.Error("Expected entry to have expired")
+	}
+}
+
+func TestShardedNegativeCaching(t *testing.T) {
+	c := NewSharded("sharded-negttl1", 4)
+
+	var calls int32
+	c.RegisterFetcherWithOptions(func(key StrKey) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		return []byte{}, ErrNotFound
+	}, FetcherOptions{NegativeTTL: 20 * time.Millisecond})
+
+	if _, err := c.Get(StrKey("missing")); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := c.Get(StrKey("missing")); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected fetcher to be called once while tombstoned, got %d calls", calls)
+	}
+
+	entry := c.PeekEntry(StrKey("missing"))
+	if entry == nil || entry.Error != ErrNotFound {
+		t.Error("expected PeekEntry to return the tombstoned entry")
+	}
+	if c.Peek(StrKey("missing")) {
+		t.Error("Peek should not report a tombstoned entry as a valid value")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	c.Get(StrKey("missing"))
+	if calls != 2 {
+		t.Errorf("expected fetcher to be retried after NegativeTTL, got %d calls", calls)
+	}
+
+	c.Invalidate(StrKey("missing"), false)
+	if c.PeekEntry(StrKey("missing")) != nil {
+		t.Error("expected Invalidate to remove the tombstone")
+	}
+}
+
+type shardedBaseKey struct {
+	id string
+}
+
+func (key shardedBaseKey) Dependencies() []CacheKey {
+	return NoDeps
+}
+
+type shardedDependentKey struct {
+	id   string
+	size int
+}
+
+func (key shardedDependentKey) Dependencies() []CacheKey {
+	return []CacheKey{shardedBaseKey{key.id}}
+}
+
+func TestShardedRecursiveInvalidate(t *testing.T) {
+	c := NewSharded("sharded-invalidate1", 4)
+	c.RegisterFetcher(func(key shardedBaseKey) ([]byte, error) {
+		return []byte("base:" + key.id), nil
+	})
+	c.RegisterFetcher(func(key shardedDependentKey) ([]byte, error) {
+		return []byte("dep:" + key.id), nil
+	})
+
+	c.Get(shardedBaseKey{"x"})
+	c.Get(shardedDependentKey{"x", 1})
+	c.Get(shardedDependentKey{"x", 2})
+
+	if !c.Peek(shardedBaseKey{"x"}) || !c.Peek(shardedDependentKey{"x", 1}) || !c.Peek(shardedDependentKey{"x", 2}) {
+		t.Fatal("expected base and dependent entries to be cached")
+	}
+
+	// Non-recursive invalidate should leave dependents alone.
+	c.Invalidate(shardedBaseKey{"x"}, false)
+	if c.Peek(shardedBaseKey{"x"}) {
+		t.Error("expected base entry to be gone")
+	}
+	if !c.Peek(shardedDependentKey{"x", 1}) || !c.Peek(shardedDependentKey{"x", 2}) {
+		t.Error("non-recursive invalidate should not affect dependents")
+	}
+
+	c.Get(shardedBaseKey{"x"})
+
+	// Recursive invalidate should remove the base entry and every dependent.
+	c.Invalidate(shardedBaseKey{"x"}, true)
+	if c.Peek(shardedBaseKey{"x"}) {
+		t.Error("expected base entry to be gone")
+	}
+	if c.Peek(shardedDependentKey{"x", 1}) {
+		t.Error("expected dependent entry (1) to have been recursively invalidated")
+	}
+	if c.Peek(shardedDependentKey{"x", 2}) {
+		t.Error("expected dependent entry (2) to have been recursively invalidated")
+	}
+}
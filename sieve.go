@@ -0,0 +1,179 @@
+// Copyright 2015 Daniel Pupius
+
+package rcache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// sieveEntry wraps a key stored in the SIEVE queue along with its visited
+// bit.
+type sieveEntry struct {
+	key     CacheKey
+	visited bool
+}
+
+type sieve struct {
+	maxSizeBytes int64
+	delegate     Cache
+	mu           sync.Mutex
+	elementMap   map[CacheKey]*list.Element
+	elementList  *list.List // newest at the front, oldest at the back.
+	hand         *list.Element
+	metrics      Metrics
+}
+
+// NewSIEVE returns a cache with a max byte size, evicted using the SIEVE
+// algorithm. SIEVE typically achieves a lower miss ratio than LRU while
+// doing less bookkeeping, since entries are never reordered on a hit; a
+// single "hand" sweeps the queue looking for an unvisited entry to evict.
+func NewSIEVE(name string, maxSizeBytes int64, opts ...Option) Cache {
+	co := newCacheOptions(opts)
+	s := &sieve{
+		maxSizeBytes: maxSizeBytes,
+		delegate:     New(name),
+		elementMap:   make(map[CacheKey]*list.Element),
+		elementList:  list.New(),
+		metrics:      co.metrics,
+	}
+	return wrapMiddleware(s, co.middleware)
+}
+
+func (s *sieve) RegisterFetcher(fn interface{}) {
+	s.delegate.RegisterFetcher(fn)
+}
+
+func (s *sieve) RegisterFetcherWithOptions(fn interface{}, opts FetcherOptions) {
+	s.delegate.RegisterFetcherWithOptions(fn, opts)
+}
+
+func (s *sieve) Entries() []CacheEntry {
+	// TODO(dan): Return copy that reflects SIEVE ordering.
+	return s.delegate.Entries()
+}
+
+func (s *sieve) Size() int64 {
+	return s.delegate.Size()
+}
+
+func (s *sieve) Invalidate(key CacheKey, recursive bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.invalidate(key, recursive)
+}
+
+// invalidate removes key from the SIEVE queue, and if recursive is true, also
+// removes any entries that depend on it. Dependents are looked up on the
+// delegate before it forgets about key, since invalidating key there also
+// drops it from the delegate's reverse dependency index. Must be called with
+// mu held.
+func (s *sieve) invalidate(key CacheKey, recursive bool) bool {
+	var dependents []CacheKey
+	if recursive {
+		if dl, ok := s.delegate.(dependentsLister); ok {
+			dependents = dl.dependentsOf(key)
+		}
+	}
+
+	ok := s.delegate.Invalidate(key, false)
+	if ok {
+		if element, found := s.elementMap[key]; found {
+			s.removeFromQueue(element)
+		}
+	}
+
+	for _, dep := range dependents {
+		s.invalidate(dep, true)
+	}
+
+	return ok
+}
+
+func (s *sieve) Peek(key CacheKey) bool {
+	return s.delegate.Peek(key)
+}
+
+func (s *sieve) PeekEntry(key CacheKey) *CacheEntry {
+	return s.delegate.PeekEntry(key)
+}
+
+func (s *sieve) GetCacheEntry(key CacheKey) *CacheEntry {
+	e := s.delegate.GetCacheEntry(key)
+
+	if e.Error == nil {
+		s.mu.Lock()
+		if element, ok := s.elementMap[key]; ok {
+			element.Value.(*sieveEntry).visited = true
+		} else {
+			s.elementMap[key] = s.elementList.PushFront(&sieveEntry{key: key})
+		}
+
+		// Evict entries until the cache is back under capacity.
+		for s.delegate.Size() > s.maxSizeBytes {
+			s.evict()
+		}
+		s.mu.Unlock()
+	}
+
+	return e
+}
+
+func (s *sieve) Get(key CacheKey) ([]byte, error) {
+	e := s.GetCacheEntry(key)
+	return e.Bytes, e.Error
+}
+
+// evict walks the hand from its current position towards the front of the
+// queue, clearing visited bits as it goes, until it finds an entry that
+// hasn't been visited since the last sweep. That entry is evicted and the
+// hand is left at the element before it, wrapping to the back if it fell
+// off the front.
+func (s *sieve) evict() {
+	hand := s.hand
+	if hand == nil {
+		hand = s.elementList.Back()
+	}
+	for hand != nil && hand.Value.(*sieveEntry).visited {
+		hand.Value.(*sieveEntry).visited = false
+		hand = hand.Prev()
+		if hand == nil {
+			hand = s.elementList.Back()
+		}
+	}
+	if hand == nil {
+		return
+	}
+	// Sync s.hand to the position the walk above landed on before removing
+	// it, so removeFromQueue's wasHand check (element == s.hand) recognizes
+	// this as the sweep's own eviction rather than an unrelated removal.
+	s.hand = hand
+	entry := hand.Value.(*sieveEntry)
+	s.removeFromQueue(hand)
+	s.delegate.Invalidate(entry.key, false)
+	if s.metrics != nil {
+		s.metrics.OnEvict(entry.key)
+	}
+}
+
+// removeFromQueue removes the given element from the SIEVE queue and its
+// index. If element is the current hand - i.e. this removal is the sweep's
+// own eviction, or a dependency cascade happened to land on the hand's
+// position - the hand is advanced to the preceding element, wrapping to the
+// back if it fell off the front, so the sweep doesn't get stuck on a
+// removed element. Removing any other element (e.g. a plain Invalidate of
+// an unrelated key) leaves the hand untouched; evict's nil-fallback to
+// elementList.Back() already covers the case where it's never been set.
+func (s *sieve) removeFromQueue(element *list.Element) {
+	wasHand := element == s.hand
+	prev := element.Prev()
+	entry := element.Value.(*sieveEntry)
+	s.elementList.Remove(element)
+	delete(s.elementMap, entry.key)
+	if wasHand {
+		if prev == nil {
+			prev = s.elementList.Back()
+		}
+		s.hand = prev
+	}
+}
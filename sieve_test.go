@@ -0,0 +1,108 @@
+// Copyright 2015 Daniel Pupius
+
+package rcache
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSIEVEBehavior(t *testing.T) {
+	sv := NewSIEVE("sieve1", 9)
+	sv.RegisterFetcher(func(key FixedSizeEntry) ([]byte, error) {
+		return []byte(strings.Repeat(".", key.size)), nil
+	})
+
+	a := FixedSizeEntry{1}
+	b := FixedSizeEntry{2}
+	c := FixedSizeEntry{3}
+	d := FixedSizeEntry{4}
+
+	sv.Get(a)
+	sv.Get(b)
+	sv.Get(c)
+
+	// Keep (a) and (c) hot, (b) stays cold.
+	sv.Get(a)
+	sv.Get(c)
+
+	sv.Get(d) // Size would be 10, something must be evicted.
+
+	// Unlike LRU, (a) is not evicted even though it's at the head of the
+	// queue; its visited bit protects it and the cold (b) is evicted first.
+	if !sv.Peek(a) {
+		t.Error("Expected entry (a) to be cached")
+	}
+	if sv.Peek(b) {
+		t.Error("Entry (b) should have been evicted")
+	}
+	if !sv.Peek(c) {
+		t.Error("Expected entry (c) to be cached")
+	}
+	if !sv.Peek(d) {
+		t.Error("Expected entry (d) to be cached")
+	}
+}
+
+func TestSIEVEEvictLeavesHandAtItsNewPosition(t *testing.T) {
+	c := NewSIEVE("sieve3", 100)
+	c.RegisterFetcher(func(key FixedSizeEntry) ([]byte, error) {
+		return []byte(strings.Repeat(".", key.size)), nil
+	})
+	sv := c.(*sieve)
+
+	c.Get(FixedSizeEntry{1})
+	c.Get(FixedSizeEntry{2})
+	c.Get(FixedSizeEntry{3})
+
+	// Each eviction must leave the hand resting on a real element so the
+	// next sweep resumes from there, rather than falling back to Back()
+	// (and re-walking the whole queue) every single time.
+	sv.evict()
+	if sv.hand == nil {
+		t.Fatal("expected hand to be left at a real element after eviction")
+	}
+	first := sv.hand
+
+	sv.evict()
+	if sv.hand == nil {
+		t.Fatal("expected hand to still be set after a second eviction")
+	}
+	if sv.hand == first {
+		t.Error("expected the second eviction to advance the hand, not repeat the first position")
+	}
+}
+
+func TestSIEVEInvalidateOfUnrelatedKeyDoesNotMoveHand(t *testing.T) {
+	c := NewSIEVE("sieve2", 100)
+	c.RegisterFetcher(func(key FixedSizeEntry) ([]byte, error) {
+		return []byte(strings.Repeat(".", key.size)), nil
+	})
+	sv := c.(*sieve)
+
+	a := FixedSizeEntry{1}
+	b := FixedSizeEntry{2}
+	e := FixedSizeEntry{3}
+	f := FixedSizeEntry{4}
+
+	c.Get(a)
+	c.Get(b)
+	c.Get(e)
+
+	// Drive a sweep by hand so the hand ends up resting on (b), mid-queue.
+	sv.evict()
+	if !sv.Peek(b) || sv.Peek(a) {
+		t.Fatal("expected the sweep to have evicted (a), leaving (b) cached")
+	}
+	hand := sv.hand
+
+	c.Get(f)
+
+	// Invalidating an unrelated key - neither the hand nor anywhere near it -
+	// must not move the hand; only a removal of the hand's own element
+	// should do that.
+	c.Invalidate(e, false)
+	if sv.hand != hand {
+		t.Error("expected hand to be unaffected by invalidating an unrelated key")
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright 2015 Daniel Pupius
+
+package rcache
+
+// Store is a pluggable, persistent second tier for a Tiered cache. Unlike
+// the in-memory Cache implementations, a Store is addressed by a plain
+// string key, since CacheKeys need to be reduced to something serializable
+// before they can be written to disk or a remote service. Tiered derives
+// that string with serializeKey, a type-qualified hash of the key's fields;
+// it rules out collisions between different CacheKey types, but two
+// distinct keys of the same type whose fields hash identically would still
+// share a Store entry, silently returning one key's value for the other's
+// Get. A custom Store can reduce that further (e.g. rejecting a Put whose
+// key doesn't match an embedded discriminator) if that residual risk
+// matters for its workload.
+type Store interface {
+	// Get returns the stored value for key and whether it was found. found
+	// is false, with a nil error, when the key simply isn't present.
+	Get(key string) (value []byte, found bool, err error)
+
+	// Put stores value for key, overwriting any existing value.
+	Put(key string, value []byte) error
+
+	// Delete removes key, if present. Deleting a key that isn't stored is
+	// not an error.
+	Delete(key string) error
+
+	// Size returns the number of bytes currently stored.
+	Size() int64
+}
@@ -0,0 +1,196 @@
+// Copyright 2015 Daniel Pupius
+
+package rcache
+
+import (
+	"reflect"
+	"sync"
+)
+
+type tiered struct {
+	l1 Cache
+	l2 Store
+
+	// dependents is a reverse index: dependents[k] holds the set of keys
+	// whose Dependencies() include k, recorded whenever a key is loaded -
+	// whether served from L2 or freshly fetched through. Recursive
+	// invalidation walks this instead of scanning l1.Entries(), so it also
+	// finds dependents that have aged out of L1 and live only in L2.
+	dependents     map[CacheKey]map[CacheKey]struct{}
+	dependentsLock sync.Mutex
+}
+
+// NewTiered returns a Cache that composes an in-memory L1 (e.g. the result
+// of New, NewLRU, NewSIEVE, or NewSharded) with a slower, larger L2 Store,
+// so that large blob workloads survive process restarts while only a
+// bounded working set is kept in RAM.
+//
+// Get first checks L1, then L2 (populating L1 on an L2 hit), and only falls
+// back to the registered fetcher on a miss in both tiers; the fetched value
+// is written through to both. Eviction of the in-memory working set is
+// governed entirely by l1 - L2 is expected to manage its own size, see
+// NewFSStore.
+func NewTiered(name string, l1 Cache, l2 Store, opts ...Option) Cache {
+	co := newCacheOptions(opts)
+	t := &tiered{l1: l1, l2: l2, dependents: make(map[CacheKey]map[CacheKey]struct{})}
+	return wrapMiddleware(t, co.middleware)
+}
+
+func (t *tiered) RegisterFetcher(fn interface{}) {
+	t.l1.RegisterFetcher(t.wrap(fn).Interface())
+}
+
+func (t *tiered) RegisterFetcherWithOptions(fn interface{}, opts FetcherOptions) {
+	t.l1.RegisterFetcherWithOptions(t.wrap(fn).Interface(), opts)
+}
+
+// wrap builds a fetcher, of the same function type as fn, that checks L2
+// before falling back to fn and writing the result through to L2. It's
+// registered with l1 in place of fn, so l1's usual fetch-on-miss path
+// transparently becomes a fetch-through-L2 path.
+func (t *tiered) wrap(fn interface{}) reflect.Value {
+	v := reflect.ValueOf(fn)
+	ft := v.Type()
+	assertValidFetcher(ft)
+
+	return reflect.MakeFunc(ft, func(args []reflect.Value) []reflect.Value {
+		key, _ := args[0].Interface().(CacheKey)
+		bytes, err := t.load(key, v)
+		errVal := reflect.Zero(errorType)
+		if err != nil {
+			errVal = reflect.ValueOf(err)
+		}
+		return []reflect.Value{reflect.ValueOf(bytes), errVal}
+	})
+}
+
+// load checks L2 for key, falling back to fetcher and writing the result
+// through to L2 on a miss.
+func (t *tiered) load(key CacheKey, fetcher reflect.Value) ([]byte, error) {
+	k := serializeKey(key)
+	if bytes, found, err := t.l2.Get(k); err == nil && found {
+		t.addDependents(key)
+		return bytes, nil
+	}
+
+	values := fetcher.Call([]reflect.Value{reflect.ValueOf(key)})
+	if errVal := values[1].Interface(); errVal != nil {
+		return []byte{}, errVal.(error)
+	}
+	bytes := values[0].Bytes()
+	t.addDependents(key)
+	if err := t.l2.Put(k, bytes); err != nil {
+		return bytes, err
+	}
+	return bytes, nil
+}
+
+// addDependents records key against each of its declared dependencies in
+// the reverse index, mirroring cache.addDependents. It's called on every
+// load - an L2 hit as well as a fetch-through - so the index reflects keys
+// that are only present in L2.
+func (t *tiered) addDependents(key CacheKey) {
+	deps := key.Dependencies()
+	if len(deps) == 0 {
+		return
+	}
+	t.dependentsLock.Lock()
+	defer t.dependentsLock.Unlock()
+	for _, dep := range deps {
+		set, ok := t.dependents[dep]
+		if !ok {
+			set = make(map[CacheKey]struct{})
+			t.dependents[dep] = set
+		}
+		set[key] = struct{}{}
+	}
+}
+
+func (t *tiered) Get(key CacheKey) ([]byte, error) {
+	return t.l1.Get(key)
+}
+
+func (t *tiered) GetCacheEntry(key CacheKey) *CacheEntry {
+	return t.l1.GetCacheEntry(key)
+}
+
+func (t *tiered) Peek(key CacheKey) bool {
+	if t.l1.Peek(key) {
+		return true
+	}
+	_, found, err := t.l2.Get(serializeKey(key))
+	return err == nil && found
+}
+
+func (t *tiered) PeekEntry(key CacheKey) *CacheEntry {
+	return t.l1.PeekEntry(key)
+}
+
+func (t *tiered) Entries() []CacheEntry {
+	return t.l1.Entries()
+}
+
+// Size returns the number of bytes held in the in-memory L1 tier. Use l2's
+// own accounting (e.g. Store.Size) to inspect the persistent tier.
+func (t *tiered) Size() int64 {
+	return t.l1.Size()
+}
+
+func (t *tiered) Invalidate(key CacheKey, recursive bool) bool {
+	keys := []CacheKey{key}
+	if recursive {
+		keys = append(keys, t.dependentsOf(key)...)
+	}
+	ok := false
+	for _, k := range keys {
+		if t.l1.Invalidate(k, false) {
+			ok = true
+		}
+		if err := t.l2.Delete(serializeKey(k)); err == nil {
+			ok = true
+		}
+		t.removeDependents(k, recursive)
+	}
+	return ok
+}
+
+// dependentsOf returns the keys, transitively, that declare key as a
+// dependency according to the reverse index built up by addDependents. It
+// finds dependents regardless of which tier they currently live in,
+// including ones that have aged out of L1 and are only in L2.
+func (t *tiered) dependentsOf(key CacheKey) []CacheKey {
+	t.dependentsLock.Lock()
+	direct := make([]CacheKey, 0, len(t.dependents[key]))
+	for k := range t.dependents[key] {
+		direct = append(direct, k)
+	}
+	t.dependentsLock.Unlock()
+
+	deps := direct
+	for _, d := range direct {
+		deps = append(deps, t.dependentsOf(d)...)
+	}
+	return deps
+}
+
+// removeDependents drops key's registration against each of its declared
+// dependencies - key is gone either way, so those sets must forget it. Its
+// own entry, dependents[key] (the set of keys that depend on key), is only
+// cleared when recursive: a non-recursive invalidate leaves key's
+// dependents cached, so that set must survive for a later recursive
+// Invalidate to still find them.
+func (t *tiered) removeDependents(key CacheKey, recursive bool) {
+	t.dependentsLock.Lock()
+	defer t.dependentsLock.Unlock()
+	for _, dep := range key.Dependencies() {
+		if set, ok := t.dependents[dep]; ok {
+			delete(set, key)
+			if len(set) == 0 {
+				delete(t.dependents, dep)
+			}
+		}
+	}
+	if recursive {
+		delete(t.dependents, key)
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2015 Daniel Pupius
+
+package rcache
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+func TestTieredPopulatesL1FromL2(t *testing.T) {
+	l2 := NewFSStore(t.TempDir(), 0)
+
+	var calls int32
+	var instances int
+	newTiered := func() Cache {
+		instances++
+		// Each call simulates a fresh process sharing l2, so its in-memory L1
+		// must get its own expvar-registered name.
+		c := NewTiered("tiered1", New(fmt.Sprintf("tiered1-l1-%d", instances)), l2)
+		c.RegisterFetcher(func(key StrKey) ([]byte, error) {
+			atomic.AddInt32(&calls, 1)
+			return []byte(key), nil
+		})
+		return c
+	}
+
+	a := newTiered()
+	if bytes, _ := a.Get(StrKey("x")); string(bytes) != "x" {
+		t.Fatalf("expected %q, got %q", "x", bytes)
+	}
+	if calls != 1 {
+		t.Fatalf("expected fetcher to be called once, got %d", calls)
+	}
+
+	// A fresh Cache sharing the same L2 should find the value there without
+	// calling the fetcher again.
+	b := newTiered()
+	if bytes, _ := b.Get(StrKey("x")); string(bytes) != "x" {
+		t.Fatalf("expected %q, got %q", "x", bytes)
+	}
+	if calls != 1 {
+		t.Errorf("expected fetcher not to be called again, got %d calls", calls)
+	}
+	if !b.Peek(StrKey("x")) {
+		t.Error("expected L2 hit to populate L1")
+	}
+}
+
+type tieredBaseKey struct {
+	id string
+}
+
+func (key tieredBaseKey) Dependencies() []CacheKey {
+	return NoDeps
+}
+
+type tieredDependentKey struct {
+	id string
+}
+
+func (key tieredDependentKey) Dependencies() []CacheKey {
+	return []CacheKey{tieredBaseKey{key.id}}
+}
+
+func TestTieredRecursiveInvalidateReachesL2OnlyDependents(t *testing.T) {
+	l2 := NewFSStore(t.TempDir(), 0)
+	// A tiny L1 so the dependent entry can be evicted from memory while it
+	// stays resident in L2.
+	c := NewTiered("tiered3", NewLRU("tiered3-l1", 2), l2)
+	c.RegisterFetcher(func(key tieredBaseKey) ([]byte, error) {
+		return []byte("b"), nil
+	})
+	c.RegisterFetcher(func(key tieredDependentKey) ([]byte, error) {
+		return []byte("d"), nil
+	})
+	c.RegisterFetcher(func(key StrKey) ([]byte, error) {
+		return []byte(key), nil
+	})
+
+	c.Get(tieredBaseKey{"x"})
+	c.Get(tieredDependentKey{"x"})
+	c.Get(tieredBaseKey{"x"}) // Bump base's recency.
+	c.Get(StrKey("filler"))   // Evicts the dependent, the least recently used.
+
+	if c.Peek(tieredDependentKey{"x"}) == false {
+		t.Fatal("expected dependent to still be served from L2")
+	}
+	if _, found, _ := l2.Get(serializeKey(tieredDependentKey{"x"})); !found {
+		t.Fatal("expected dependent to be present in L2")
+	}
+
+	c.Invalidate(tieredBaseKey{"x"}, true)
+
+	if c.Peek(tieredDependentKey{"x"}) {
+		t.Error("expected L2-only dependent to be recursively invalidated")
+	}
+	if _, found, _ := l2.Get(serializeKey(tieredDependentKey{"x"})); found {
+		t.Error("expected dependent to be removed from L2")
+	}
+}
+
+func TestTieredNonRecursiveInvalidateKeepsDependentsIndex(t *testing.T) {
+	l2 := NewFSStore(t.TempDir(), 0)
+	c := NewTiered("tiered4", New("tiered4-l1"), l2)
+	c.RegisterFetcher(func(key tieredBaseKey) ([]byte, error) {
+		return []byte("b"), nil
+	})
+	c.RegisterFetcher(func(key tieredDependentKey) ([]byte, error) {
+		return []byte("d"), nil
+	})
+
+	c.Get(tieredBaseKey{"y"})
+	c.Get(tieredDependentKey{"y"})
+
+	// A non-recursive invalidate of the base shouldn't touch its dependent,
+	// nor should it forget that the dependent still depends on it.
+	c.Invalidate(tieredBaseKey{"y"}, false)
+	if !c.Peek(tieredDependentKey{"y"}) {
+		t.Fatal("expected dependent to still be cached")
+	}
+
+	c.Get(tieredBaseKey{"y"})
+
+	// A later recursive invalidate must still cascade to the dependent.
+	c.Invalidate(tieredBaseKey{"y"}, true)
+	if c.Peek(tieredDependentKey{"y"}) {
+		t.Error("expected recursive invalidate to reach the dependent even after an earlier non-recursive invalidate")
+	}
+}
+
+func TestTieredInvalidatePropagatesToL2(t *testing.T) {
+	l2 := NewFSStore(t.TempDir(), 0)
+	c := NewTiered("tiered2", New("tiered2-l1"), l2)
+	c.RegisterFetcher(func(key StrKey) ([]byte, error) {
+		return []byte(key), nil
+	})
+
+	c.Get(StrKey("x"))
+	c.Invalidate(StrKey("x"), false)
+
+	if c.Peek(StrKey("x")) {
+		t.Error("expected entry to be gone from both tiers")
+	}
+	if _, found, _ := l2.Get(serializeKey(StrKey("x"))); found {
+		t.Error("expected L2 entry to be removed by Invalidate")
+	}
+}